@@ -0,0 +1,79 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ * Copyright (C) 2018 Cisco
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// annotationFlag and layerAnnotationFlag are the common flags shared by
+// "insert" and "repack" for attaching OCI annotations to the manifest and
+// to the new layer descriptor respectively.
+var (
+	annotationFlag      = "annotation"
+	layerAnnotationFlag = "layer-annotation"
+)
+
+// annotationFlags returns the cli.Flag definitions for --annotation and
+// --layer-annotation, so that "insert" and "repack" don't have to repeat
+// them.
+func annotationFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  annotationFlag,
+			Usage: "manifest annotation to set, as key=value (may be repeated)",
+		},
+		cli.StringSliceFlag{
+			Name:  layerAnnotationFlag,
+			Usage: "annotation to set on the new layer descriptor, as key=value (may be repeated)",
+		},
+	}
+}
+
+// parseAnnotations parses a list of "key=value" flag values (as produced by
+// cli.Context.StringSlice) into a map, erroring out on anything that isn't
+// of that form.
+func parseAnnotations(kvs []string) (map[string]string, error) {
+	if len(kvs) == 0 {
+		return nil, nil
+	}
+
+	annotations := map[string]string{}
+	for _, kv := range kvs {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("invalid annotation %q: expected key=value", kv)
+		}
+		annotations[parts[0]] = parts[1]
+	}
+	return annotations, nil
+}
+
+// manifestAnnotations and layerAnnotations parse the --annotation and
+// --layer-annotation flags off ctx.
+func manifestAnnotations(ctx *cli.Context) (map[string]string, error) {
+	return parseAnnotations(ctx.StringSlice(annotationFlag))
+}
+
+func layerAnnotations(ctx *cli.Context) (map[string]string, error) {
+	return parseAnnotations(ctx.StringSlice(layerAnnotationFlag))
+}
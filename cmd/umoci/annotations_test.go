@@ -0,0 +1,100 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ * Copyright (C) 2018 Cisco
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+// newTestContext builds a *cli.Context with the given flags applied and
+// parsed from args, for exercising flag-parsing helpers (layerAddOptions,
+// manifestAnnotations, ...) without going through a full cli.App.
+func newTestContext(t *testing.T, flags []cli.Flag, args []string) *cli.Context {
+	t.Helper()
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range flags {
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatalf("parse flags %v: %v", args, err)
+	}
+	return cli.NewContext(nil, set, nil)
+}
+
+func TestParseAnnotations(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		in      []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"empty", nil, nil, false},
+		{"single", []string{"key=value"}, map[string]string{"key": "value"}, false},
+		{"multiple", []string{"a=1", "b=2"}, map[string]string{"a": "1", "b": "2"}, false},
+		{"value contains equals", []string{"key=a=b"}, map[string]string{"key": "a=b"}, false},
+		{"repeated key last wins", []string{"key=1", "key=2"}, map[string]string{"key": "2"}, false},
+		{"missing equals", []string{"novalue"}, nil, true},
+		{"empty key", []string{"=value"}, nil, true},
+		{"empty value is fine", []string{"key="}, map[string]string{"key": ""}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseAnnotations(test.in)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("parseAnnotations(%v) = %v, want error", test.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAnnotations(%v) unexpected error: %v", test.in, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseAnnotations(%v) = %v, want %v", test.in, got, test.want)
+			}
+		})
+	}
+}
+
+func TestManifestAndLayerAnnotations(t *testing.T) {
+	ctx := newTestContext(t, annotationFlags(), []string{
+		"--annotation", "org.opencontainers.image.version=1.0",
+		"--layer-annotation", "com.example.layer=yes",
+	})
+
+	manifest, err := manifestAnnotations(ctx)
+	if err != nil {
+		t.Fatalf("manifestAnnotations: %v", err)
+	}
+	if want := map[string]string{"org.opencontainers.image.version": "1.0"}; !reflect.DeepEqual(manifest, want) {
+		t.Errorf("manifestAnnotations() = %v, want %v", manifest, want)
+	}
+
+	layer, err := layerAnnotations(ctx)
+	if err != nil {
+		t.Fatalf("layerAnnotations: %v", err)
+	}
+	if want := map[string]string{"com.example.layer": "yes"}; !reflect.DeepEqual(layer, want) {
+		t.Errorf("layerAnnotations() = %v, want %v", layer, want)
+	}
+}
@@ -20,10 +20,12 @@ package main
 
 import (
 	"context"
+	"io"
 	"time"
 
 	"github.com/apex/log"
 	"github.com/openSUSE/umoci/mutate"
+	"github.com/openSUSE/umoci/oci/cas/cache"
 	"github.com/openSUSE/umoci/oci/cas/dir"
 	"github.com/openSUSE/umoci/oci/casext"
 	igen "github.com/openSUSE/umoci/oci/config/generate"
@@ -33,6 +35,13 @@ import (
 	"github.com/urfave/cli"
 )
 
+// whiteoutFlag and opaqueFlag select insert's delete mode -- see
+// insertCommand's ArgsUsage for details.
+var (
+	whiteoutFlag = "whiteout"
+	opaqueFlag   = "opaque"
+)
+
 var insertCommand = uxRemap(uxHistory(cli.Command{
 	Name:  "insert",
 	Usage: "insert a file into an OCI image without unpacking/repacking it",
@@ -42,30 +51,83 @@ Where "<image-path>" is the path to the OCI image, "<tag>" is the name of the
 tag that the content wil be inserted into (if not specified, defaults to
 "latest"), "<file>" is the file or folder to insert, and "<path>" is the full
 name of the path to that the file should be inserted at. Insert is
-automatically recursive if the source is a directory.
+automatically recursive if the source is a directory. If "<file>" is a tar
+or tar.gz archive, its contents are splatted at "<path>" directly, without
+needing to be extracted to a scratch directory first.
+
+With --whiteout, no "<file>" argument is given -- instead "<path>" is
+removed from the image by writing an OCI/AUFS whiteout entry, without
+needing an unpack/repack round-trip. Combine --whiteout with --opaque to
+remove "<path>"'s prior contents recursively while keeping "<path>" itself
+(useful for directories that are about to be repopulated).
+
+If "<tag>" resolves to an image index rather than a single manifest, you
+must specify which child manifest to insert into with --platform, or pass
+--all-platforms to insert into every applicable child manifest.
 
 For example:
 	umoci insert --image oci:foo mybinary /usr/bin/mybinary
 	umoci insert --image oci:foo myconfigdir /etc/myconfigdir
+	umoci insert --image oci:foo --platform linux/arm64 mybinary /usr/bin/mybinary
+	umoci insert --image oci:foo --whiteout /etc/secret.conf
 `,
 
 	Category: "image",
 
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  platformFlag,
+			Usage: "os/arch[/variant] of the manifest to insert into, if <tag> is an image index",
+		},
+		cli.BoolFlag{
+			Name:  allPlatformsFlag,
+			Usage: "insert into every manifest referenced by an image index",
+		},
+		cli.BoolFlag{
+			Name:  whiteoutFlag,
+			Usage: "remove <path> from the image instead of inserting into it (no <file> argument)",
+		},
+		cli.BoolFlag{
+			Name:  opaqueFlag,
+			Usage: "with --whiteout, recursively remove <path>'s contents but keep <path> itself",
+		},
+	}, append(layerAddFlags(), annotationFlags()...)...),
+
 	Action: insert,
 
 	Before: func(ctx *cli.Context) error {
-		if ctx.NArg() != 2 {
-			return errors.Errorf("invalid number of positional arguments: expected <file> and <path>")
+		whiteout := ctx.Bool(whiteoutFlag)
+
+		if whiteout {
+			if ctx.NArg() != 1 {
+				return errors.Errorf("invalid number of positional arguments: expected <path> (no <file> with --whiteout)")
+			}
+			if ctx.Args()[0] == "" {
+				return errors.Errorf("<path> cannot be empty")
+			}
+			ctx.App.Metadata["insertPath"] = ctx.Args()[0]
+		} else {
+			if ctx.NArg() != 2 {
+				return errors.Errorf("invalid number of positional arguments: expected <file> and <path>")
+			}
+			if ctx.Args()[0] == "" {
+				return errors.Errorf("<file> cannot be empty")
+			}
+			ctx.App.Metadata["insertFile"] = ctx.Args()[0]
+
+			if ctx.Args()[1] == "" {
+				return errors.Errorf("<path> cannot be empty")
+			}
+			ctx.App.Metadata["insertPath"] = ctx.Args()[1]
 		}
-		if ctx.Args()[0] == "" {
-			return errors.Errorf("<file> cannot be empty")
+
+		if ctx.Bool(opaqueFlag) && !whiteout {
+			return errors.Errorf("--opaque can only be used together with --whiteout")
 		}
-		ctx.App.Metadata["insertFile"] = ctx.Args()[0]
 
-		if ctx.Args()[1] == "" {
-			return errors.Errorf("<path> cannot be empty")
+		if ctx.String(platformFlag) != "" && ctx.Bool(allPlatformsFlag) {
+			return errors.Errorf("--platform and --all-platforms are mutually exclusive")
 		}
-		ctx.App.Metadata["insertPath"] = ctx.Args()[1]
 
 		return nil
 	},
@@ -83,6 +145,13 @@ func insert(ctx *cli.Context) error {
 	engineExt := casext.NewEngine(engine)
 	defer engine.Close()
 
+	cacheStore, err := cache.Open(imagePath)
+	if err != nil {
+		return errors.Wrap(err, "open cache")
+	}
+	defer cacheStore.Close()
+	engineExt = engineExt.SetCache(cacheStore)
+
 	descriptorPaths, err := engineExt.ResolveReference(context.Background(), tagName)
 	if err != nil {
 		return errors.Wrap(err, "get descriptor")
@@ -90,18 +159,19 @@ func insert(ctx *cli.Context) error {
 	if len(descriptorPaths) == 0 {
 		return errors.Errorf("tag not found: %s", tagName)
 	}
-	if len(descriptorPaths) != 1 {
-		// TODO: Handle this more nicely.
-		return errors.Errorf("tag is ambiguous: %s", tagName)
-	}
 
-	// Create the mutator.
-	mutator, err := mutate.New(engine, descriptorPaths[0])
+	var platform *ispec.Platform
+	if platformArg := ctx.String(platformFlag); platformArg != "" {
+		platform, err = parsePlatform(platformArg)
+		if err != nil {
+			return err
+		}
+	}
+	descriptorPaths, err = selectDescriptorPaths(descriptorPaths, platform, ctx.Bool(allPlatformsFlag))
 	if err != nil {
-		return errors.Wrap(err, "create mutator for base image")
+		return err
 	}
 
-	insertFile := ctx.App.Metadata["insertFile"].(string)
 	insertPath := ctx.App.Metadata["insertPath"].(string)
 
 	var meta UmociMeta
@@ -113,8 +183,48 @@ func insert(ctx *cli.Context) error {
 		return err
 	}
 
-	reader := layer.GenerateInsertLayer(insertFile, insertPath, &meta.MapOptions)
-	defer reader.Close()
+	var generateReader func() io.ReadCloser
+	switch {
+	case ctx.Bool(whiteoutFlag) && ctx.Bool(opaqueFlag):
+		generateReader = func() io.ReadCloser {
+			return layer.GenerateInsertLayer(layer.OpOpaqueWhiteout, "", insertPath, &meta.MapOptions)
+		}
+	case ctx.Bool(whiteoutFlag):
+		generateReader = func() io.ReadCloser {
+			return layer.GenerateInsertLayer(layer.OpWhiteout, "", insertPath, &meta.MapOptions)
+		}
+	default:
+		insertFile := ctx.App.Metadata["insertFile"].(string)
+
+		// If <file> is a tar (or tar.gz) archive, splat its contents at
+		// <path> instead of requiring the caller to extract it first.
+		isTar, err := layer.IsTarball(insertFile)
+		if err != nil {
+			return errors.Wrap(err, "check if insert source is a tarball")
+		}
+		if isTar {
+			generateReader = func() io.ReadCloser {
+				return layer.GenerateInsertLayerFromTar(insertFile, insertPath, &meta.MapOptions)
+			}
+		} else {
+			generateReader = func() io.ReadCloser {
+				return layer.GenerateInsertLayer(layer.OpAdd, insertFile, insertPath, &meta.MapOptions)
+			}
+		}
+	}
+
+	addOptions, err := layerAddOptions(ctx)
+	if err != nil {
+		return err
+	}
+	addOptions.Annotations, err = layerAnnotations(ctx)
+	if err != nil {
+		return err
+	}
+	newAnnotations, err := manifestAnnotations(ctx)
+	if err != nil {
+		return err
+	}
 
 	created := time.Now()
 	history := ispec.History{
@@ -141,20 +251,39 @@ func insert(ctx *cli.Context) error {
 		history.CreatedBy = val.(string)
 	}
 
-	// TODO: We should add a flag to allow for a new layer to be made
-	//       non-distributable.
-	if err := mutator.Add(context.Background(), reader, history); err != nil {
-		return errors.Wrap(err, "add diff layer")
+	// Mutate each selected manifest in turn (there will only be more than
+	// one if --all-platforms was given and the tag resolved to an image
+	// index).
+	newDescriptors := make([]ispec.Descriptor, len(descriptorPaths))
+	for i, descriptorPath := range descriptorPaths {
+		mutator, err := mutate.New(engineExt, descriptorPath)
+		if err != nil {
+			return errors.Wrap(err, "create mutator for base image")
+		}
+
+		reader := generateReader()
+
+		addErr := mutator.Add(context.Background(), reader, history, addOptions)
+		reader.Close()
+		if addErr != nil {
+			return errors.Wrap(addErr, "add diff layer")
+		}
+
+		newDescriptorPath, err := mutator.Commit(context.Background(), newAnnotations)
+		if err != nil {
+			return errors.Wrap(err, "commit mutated image")
+		}
+		newDescriptors[i] = newDescriptorPath.Descriptor()
+
+		log.Infof("new image manifest created: %s->%s", descriptorPath.Descriptor().Digest, newDescriptorPath.Descriptor().Digest)
 	}
 
-	newDescriptorPath, err := mutator.Commit(context.Background())
+	newRoot, err := updateIndexEntries(context.Background(), engineExt, descriptorPaths, newDescriptors)
 	if err != nil {
-		return errors.Wrap(err, "commit mutated image")
+		return errors.Wrap(err, "update index")
 	}
 
-	log.Infof("new image manifest created: %s->%s", newDescriptorPath.Root().Digest, newDescriptorPath.Descriptor().Digest)
-
-	if err := engineExt.UpdateReference(context.Background(), tagName, newDescriptorPath.Root()); err != nil {
+	if err := engineExt.UpdateReference(context.Background(), tagName, newRoot); err != nil {
 		return errors.Wrap(err, "add new tag")
 	}
 	log.Infof("updated tag for image manifest: %s", tagName)
@@ -0,0 +1,68 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ * Copyright (C) 2018 Cisco
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"github.com/openSUSE/umoci/mutate"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// nonDistributableFlag and layerURLFlag are the common flags shared by
+// "insert" and "repack" for producing a non-distributable layer.
+var (
+	nonDistributableFlag = "non-distributable"
+	layerURLFlag         = "layer-url"
+)
+
+// layerAddFlags returns the cli.Flag definitions for the non-distributable
+// layer flags, so that "insert" and "repack" don't have to repeat them.
+func layerAddFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.BoolFlag{
+			Name:  nonDistributableFlag,
+			Usage: "mark the new layer as non-distributable",
+		},
+		cli.StringSliceFlag{
+			Name:  layerURLFlag,
+			Usage: "source URL for a non-distributable layer (may be repeated)",
+		},
+	}
+}
+
+// layerAddOptions builds the mutate.AddOptions implied by the
+// non-distributable layer flags on ctx.
+func layerAddOptions(ctx *cli.Context) (mutate.AddOptions, error) {
+	var opts mutate.AddOptions
+
+	urls := ctx.StringSlice(layerURLFlag)
+	nonDistributable := ctx.Bool(nonDistributableFlag)
+
+	if len(urls) > 0 && !nonDistributable {
+		return opts, errors.Errorf("--layer-url can only be used together with --non-distributable")
+	}
+
+	if nonDistributable {
+		opts.MediaType = ispec.MediaTypeImageLayerNonDistributable
+		opts.URLs = urls
+	}
+
+	return opts, nil
+}
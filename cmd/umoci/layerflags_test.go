@@ -0,0 +1,67 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ * Copyright (C) 2018 Cisco
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestLayerAddOptions(t *testing.T) {
+	// No flags set: a plain, distributable layer.
+	ctx := newTestContext(t, layerAddFlags(), nil)
+	opts, err := layerAddOptions(ctx)
+	if err != nil {
+		t.Fatalf("layerAddOptions() with no flags: %v", err)
+	}
+	if opts.MediaType != "" || len(opts.URLs) != 0 {
+		t.Errorf("layerAddOptions() with no flags = %+v, want zero value", opts)
+	}
+
+	// --non-distributable alone: media type set, no URLs.
+	ctx = newTestContext(t, layerAddFlags(), []string{"--non-distributable"})
+	opts, err = layerAddOptions(ctx)
+	if err != nil {
+		t.Fatalf("layerAddOptions() with --non-distributable: %v", err)
+	}
+	if opts.MediaType != ispec.MediaTypeImageLayerNonDistributable {
+		t.Errorf("layerAddOptions() with --non-distributable: MediaType = %q, want %q", opts.MediaType, ispec.MediaTypeImageLayerNonDistributable)
+	}
+
+	// --non-distributable with repeated --layer-url: both collected.
+	ctx = newTestContext(t, layerAddFlags(), []string{
+		"--non-distributable",
+		"--layer-url", "https://example.com/a",
+		"--layer-url", "https://example.com/b",
+	})
+	opts, err = layerAddOptions(ctx)
+	if err != nil {
+		t.Fatalf("layerAddOptions() with --layer-url: %v", err)
+	}
+	if len(opts.URLs) != 2 || opts.URLs[0] != "https://example.com/a" || opts.URLs[1] != "https://example.com/b" {
+		t.Errorf("layerAddOptions() with --layer-url: URLs = %v, want both URLs in order", opts.URLs)
+	}
+
+	// --layer-url without --non-distributable is rejected.
+	ctx = newTestContext(t, layerAddFlags(), []string{"--layer-url", "https://example.com/a"})
+	if _, err := layerAddOptions(ctx); err == nil {
+		t.Errorf("layerAddOptions() with --layer-url but no --non-distributable should have errored")
+	}
+}
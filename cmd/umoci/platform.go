@@ -0,0 +1,163 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ * Copyright (C) 2018 Cisco
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/openSUSE/umoci/oci/casext"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// platformFlag is the common --platform flag shared by commands that need
+// to select a single manifest out of an image index.
+var platformFlag = "platform"
+
+// allPlatformsFlag is the --all-platforms flag used by commands that can
+// apply their mutation to every manifest within an image index (currently
+// only "insert" -- "repack" operates on a single unpacked bundle, so it
+// can only ever target one platform at a time).
+var allPlatformsFlag = "all-platforms"
+
+// parsePlatform parses a "os/arch[/variant]" string, as used by the
+// --platform flag, into an ispec.Platform.
+func parsePlatform(s string) (*ispec.Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, errors.Errorf("invalid --platform %q: expected os/arch[/variant]", s)
+	}
+
+	platform := &ispec.Platform{
+		OS:           parts[0],
+		Architecture: parts[1],
+	}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+// matchesPlatform returns whether descriptor's Platform field is compatible
+// with the given platform selector.
+func matchesPlatform(descriptor ispec.Descriptor, platform *ispec.Platform) bool {
+	if descriptor.Platform == nil {
+		return false
+	}
+	if descriptor.Platform.OS != platform.OS || descriptor.Platform.Architecture != platform.Architecture {
+		return false
+	}
+	return platform.Variant == "" || descriptor.Platform.Variant == platform.Variant
+}
+
+// selectDescriptorPaths takes the (possibly ambiguous) set of manifest
+// paths returned by casext.ResolveReference and narrows it down according
+// to the --platform and --all-platforms flags, returning an error if the
+// reference is still ambiguous afterwards.
+func selectDescriptorPaths(paths []casext.DescriptorPath, platform *ispec.Platform, allPlatforms bool) ([]casext.DescriptorPath, error) {
+	if allPlatforms {
+		return paths, nil
+	}
+
+	if platform != nil {
+		var matched []casext.DescriptorPath
+		for _, path := range paths {
+			if matchesPlatform(path.Descriptor(), platform) {
+				matched = append(matched, path)
+			}
+		}
+		if len(matched) == 0 {
+			return nil, errors.Errorf("no manifest in index matches --platform")
+		}
+		if len(matched) != 1 {
+			return nil, errors.Errorf("--platform selection is still ambiguous: matched %d manifests", len(matched))
+		}
+		return matched, nil
+	}
+
+	if len(paths) != 1 {
+		// TODO: Handle this more nicely.
+		return nil, errors.Errorf("tag is ambiguous: resolved to %d manifests, use --platform or --all-platforms", len(paths))
+	}
+	return paths, nil
+}
+
+// updateIndexEntries rewrites the parent image index (if any) of each
+// original descriptor path so that it points to the corresponding updated
+// descriptor, preserving the Platform and Annotations of every sibling
+// entry that wasn't touched. It returns the descriptor that the image's
+// reference should now point to. If none of the originals went through an
+// index (i.e. they were plain manifest references), the single updated
+// descriptor is returned unchanged.
+func updateIndexEntries(ctx context.Context, engineExt casext.Engine, originals []casext.DescriptorPath, updated []ispec.Descriptor) (ispec.Descriptor, error) {
+	if len(originals) != len(updated) {
+		return ispec.Descriptor{}, errors.Errorf("internal error: %d originals but %d updated descriptors", len(originals), len(updated))
+	}
+
+	if len(originals[0].Walk) == 1 {
+		// Plain manifest reference -- no parent index to rewrite.
+		return updated[0], nil
+	}
+
+	root := originals[0].Root()
+	indexBlob, err := engineExt.FromDescriptor(ctx, root)
+	if err != nil {
+		return ispec.Descriptor{}, errors.Wrap(err, "get index")
+	}
+	defer indexBlob.Close()
+
+	index, ok := indexBlob.Data.(ispec.Index)
+	if !ok {
+		return ispec.Descriptor{}, errors.Errorf("index blob type not implemented: %s", indexBlob.MediaType)
+	}
+
+	for i, original := range originals {
+		oldDigest := original.Descriptor().Digest
+		newDescriptor := updated[i]
+
+		found := false
+		for j, child := range index.Manifests {
+			if child.Digest != oldDigest {
+				continue
+			}
+			// Preserve the sibling-identifying fields that aren't part of
+			// the mutation itself.
+			newDescriptor.Platform = child.Platform
+			newDescriptor.Annotations = child.Annotations
+			index.Manifests[j] = newDescriptor
+			found = true
+			break
+		}
+		if !found {
+			return ispec.Descriptor{}, errors.Errorf("could not find original manifest %s in parent index", oldDigest)
+		}
+	}
+
+	newIndexDigest, newIndexSize, err := engineExt.PutBlobJSON(ctx, index)
+	if err != nil {
+		return ispec.Descriptor{}, errors.Wrap(err, "put updated index blob")
+	}
+
+	return ispec.Descriptor{
+		MediaType: ispec.MediaTypeImageIndex,
+		Digest:    newIndexDigest,
+		Size:      newIndexSize,
+	}, nil
+}
@@ -0,0 +1,96 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ * Copyright (C) 2018 Cisco
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/openSUSE/umoci/oci/casext"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func descriptorPath(os, arch string) casext.DescriptorPath {
+	return casext.DescriptorPath{Walk: []ispec.Descriptor{
+		{MediaType: ispec.MediaTypeImageManifest, Platform: &ispec.Platform{OS: os, Architecture: arch}},
+	}}
+}
+
+func TestParsePlatform(t *testing.T) {
+	for _, test := range []struct {
+		in      string
+		os      string
+		arch    string
+		variant string
+		wantErr bool
+	}{
+		{"linux/amd64", "linux", "amd64", "", false},
+		{"linux/arm/v7", "linux", "arm", "v7", false},
+		{"linux", "", "", "", true},
+		{"linux/arm/v7/extra", "", "", "", true},
+	} {
+		got, err := parsePlatform(test.in)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parsePlatform(%q) = %+v, want error", test.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePlatform(%q) unexpected error: %v", test.in, err)
+			continue
+		}
+		if got.OS != test.os || got.Architecture != test.arch || got.Variant != test.variant {
+			t.Errorf("parsePlatform(%q) = %+v, want os=%q arch=%q variant=%q", test.in, got, test.os, test.arch, test.variant)
+		}
+	}
+}
+
+func TestSelectDescriptorPaths(t *testing.T) {
+	paths := []casext.DescriptorPath{
+		descriptorPath("linux", "amd64"),
+		descriptorPath("linux", "arm64"),
+	}
+
+	// --all-platforms returns everything, unfiltered.
+	all, err := selectDescriptorPaths(paths, nil, true)
+	if err != nil || len(all) != 2 {
+		t.Errorf("selectDescriptorPaths(allPlatforms=true) = %+v, %v; want 2 paths, no error", all, err)
+	}
+
+	// --platform narrows down to the single match.
+	amd64, err := parsePlatform("linux/amd64")
+	if err != nil {
+		t.Fatalf("parsePlatform: %v", err)
+	}
+	matched, err := selectDescriptorPaths(paths, amd64, false)
+	if err != nil || len(matched) != 1 || matched[0].Descriptor().Platform.Architecture != "amd64" {
+		t.Errorf("selectDescriptorPaths(--platform linux/amd64) = %+v, %v; want single amd64 match", matched, err)
+	}
+
+	// No platform selector and more than one candidate is ambiguous.
+	if _, err := selectDescriptorPaths(paths, nil, false); err == nil {
+		t.Errorf("selectDescriptorPaths() with ambiguous paths and no selector should have errored")
+	}
+
+	// A single already-unambiguous path doesn't require a selector.
+	single, err := selectDescriptorPaths(paths[:1], nil, false)
+	if err != nil || len(single) != 1 {
+		t.Errorf("selectDescriptorPaths() with a single path = %+v, %v; want that path, no error", single, err)
+	}
+}
@@ -1,6 +1,7 @@
 /*
  * umoci: Umoci Modifies Open Containers' Images
- * Copyright (C) 2016 SUSE LLC.
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ * Copyright (C) 2018 Cisco
  *
  * Licensed under the Apache License, Version 2.0 (the "License");
  * you may not use this file except in compliance with the License.
@@ -18,220 +19,218 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"os"
 	"path/filepath"
-
-	"github.com/Sirupsen/logrus"
-	"github.com/cyphar/umoci/image/cas"
-	"github.com/cyphar/umoci/image/generator"
-	"github.com/cyphar/umoci/image/layerdiff"
-	"github.com/opencontainers/image-spec/specs-go/v1"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/openSUSE/umoci/mutate"
+	"github.com/openSUSE/umoci/oci/cas/cache"
+	"github.com/openSUSE/umoci/oci/cas/dir"
+	"github.com/openSUSE/umoci/oci/casext"
+	igen "github.com/openSUSE/umoci/oci/config/generate"
+	"github.com/openSUSE/umoci/oci/layer"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
 	"github.com/urfave/cli"
 	"github.com/vbatts/go-mtree"
-	"golang.org/x/net/context"
 )
 
-var repackCommand = cli.Command{
+// rootfsName is the name of the directory inside a runtime bundle that
+// contains the unpacked root filesystem.
+const rootfsName = "rootfs"
+
+var repackCommand = uxRemap(uxHistory(cli.Command{
 	Name:  "repack",
 	Usage: "repacks an OCI runtime bundle into a reference",
-	ArgsUsage: `--image <image-path> --from <reference> --bundle <bundle-path>
+	ArgsUsage: `--image <image-path>[:<tag>] --from <reference> <bundle-path>
 
-Where "<image-path>" is the path to the OCI image, "<reference>" is the name of
-the reference descriptor which was used to generate the original runtime bundle
-and "<bundle-path>" is the destination to repack the image to.
+Where "<image-path>" is the path to the OCI image, "<tag>" is the name of the
+new tag to create (if not specified, defaults to "latest"), "<reference>" is
+the name of the reference descriptor that was used to generate the runtime
+bundle and "<bundle-path>" is the bundle to repack.
 
-It should be noted that this is not the same as oci-create-layer because it
-uses go-mtree to create diff layers from runtime bundles unpacked with
-umoci-unpack(1). In addition, it modifies the image so that all of the relevant
-manifest and configuration information uses the new diff atop the old manifest.`,
+If "<reference>" resolves to an image index rather than a single manifest,
+you must specify which child manifest to repack with --platform. Unlike
+"umoci insert", "repack" has no --all-platforms option: a single
+"<bundle-path>" holds the unpacked rootfs (and mtree diff) for exactly one
+platform, so there is no sound way to apply it to every child manifest in
+the index.`,
 
-	Flags: []cli.Flag{
-		// FIXME: This really should be a global option.
-		cli.StringFlag{
-			Name:  "image",
-			Usage: "path to OCI image bundle",
-		},
+	Category: "image",
+
+	Flags: append([]cli.Flag{
 		cli.StringFlag{
 			Name:  "from",
 			Usage: "reference descriptor name to repack",
 		},
 		cli.StringFlag{
-			Name:  "bundle",
-			Usage: "destination bundle path",
-		},
-		cli.StringFlag{
-			Name:  "tag",
-			Usage: "tag name for repacked image",
+			Name:  platformFlag,
+			Usage: "os/arch[/variant] of the manifest to repack, if --from is an image index",
 		},
-	},
+	}, append(layerAddFlags(), annotationFlags()...)...),
 
 	Action: repack,
-}
+
+	Before: func(ctx *cli.Context) error {
+		if ctx.NArg() != 1 {
+			return errors.Errorf("invalid number of positional arguments: expected <bundle-path>")
+		}
+		if ctx.Args()[0] == "" {
+			return errors.Errorf("<bundle-path> cannot be empty")
+		}
+		ctx.App.Metadata["bundlePath"] = ctx.Args()[0]
+
+		if ctx.String("from") == "" {
+			return errors.Errorf("reference name cannot be empty")
+		}
+
+		return nil
+	},
+}))
 
 func repack(ctx *cli.Context) error {
-	// FIXME: Is there a nicer way of dealing with mandatory arguments?
-	imagePath := ctx.String("image")
-	if imagePath == "" {
-		return fmt.Errorf("image path cannot be empty")
-	}
-	bundlePath := ctx.String("bundle")
-	if bundlePath == "" {
-		return fmt.Errorf("bundle path cannot be empty")
-	}
+	imagePath := ctx.App.Metadata["--image-path"].(string)
+	tagName := ctx.App.Metadata["--image-tag"].(string)
+	bundlePath := ctx.App.Metadata["bundlePath"].(string)
 	fromName := ctx.String("from")
-	if fromName == "" {
-		return fmt.Errorf("reference name cannot be empty")
-	}
 
 	// Get a reference to the CAS.
-	engine, err := cas.Open(imagePath)
+	engine, err := dir.Open(imagePath)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "open CAS")
 	}
+	engineExt := casext.NewEngine(engine)
 	defer engine.Close()
 
-	fromDescriptor, err := engine.GetReference(context.TODO(), fromName)
+	cacheStore, err := cache.Open(imagePath)
 	if err != nil {
-		return err
-	}
-
-	// FIXME: Implement support for manifest lists.
-	if fromDescriptor.MediaType != v1.MediaTypeImageManifest {
-		return fmt.Errorf("--from descriptor does not point to v1.MediaTypeImageManifest: not implemented: %s", fromDescriptor.MediaType)
+		return errors.Wrap(err, "open cache")
 	}
+	defer cacheStore.Close()
+	engineExt = engineExt.SetCache(cacheStore)
 
-	// FIXME: We should probably fix this so we don't use ':' in a pathname.
-	mtreePath := filepath.Join(bundlePath, fromDescriptor.Digest+".mtree")
-	fullRootfsPath := filepath.Join(bundlePath, rootfsName)
-
-	logrus.WithFields(logrus.Fields{
-		"image":  imagePath,
-		"bundle": bundlePath,
-		"ref":    fromName,
-		"rootfs": rootfsName,
-		"mtree":  mtreePath,
-	}).Debugf("umoci: repacking OCI image")
-
-	mfh, err := os.Open(mtreePath)
+	descriptorPaths, err := engineExt.ResolveReference(context.Background(), fromName)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "get descriptor")
+	}
+	if len(descriptorPaths) == 0 {
+		return errors.Errorf("--from not found: %s", fromName)
 	}
-	defer mfh.Close()
 
-	spec, err := mtree.ParseSpec(mfh)
+	var platform *ispec.Platform
+	if platformArg := ctx.String(platformFlag); platformArg != "" {
+		platform, err = parsePlatform(platformArg)
+		if err != nil {
+			return err
+		}
+	}
+	// repack has no --all-platforms: a single <bundle-path> only ever holds
+	// one platform's unpacked rootfs and mtree diff, so --platform (or an
+	// already-unambiguous reference) is the only way to select a manifest.
+	descriptorPaths, err = selectDescriptorPaths(descriptorPaths, platform, false)
 	if err != nil {
 		return err
 	}
 
-	keywords := mtree.CollectUsedKeywords(spec)
+	fullRootfsPath := filepath.Join(bundlePath, rootfsName)
 
-	diffs, err := mtree.Check(fullRootfsPath, spec, keywords)
-	if err != nil {
-		return err
-	}
+	var meta UmociMeta
+	meta.Version = UmociMetaVersion
 
-	reader, err := layerdiff.GenerateLayer(fullRootfsPath, diffs)
-	if err != nil {
+	if err := parseIdmapOptions(&meta, ctx); err != nil {
 		return err
 	}
-	defer reader.Close()
-
-	// XXX: I get the feeling all of this should be moved to a separate package
-	//      which abstracts this nicely.
 
-	layerDigest, layerSize, err := engine.PutBlob(context.TODO(), reader)
-	if err != nil {
-		return err
+	created := time.Now()
+	history := ispec.History{
+		Comment:    "",
+		Created:    &created,
+		CreatedBy:  "umoci repack", // XXX: Should we append argv to this?
+		EmptyLayer: false,
 	}
-	reader.Close()
-	// XXX: Should we defer a DeleteBlob?
 
-	layerDescriptor := &v1.Descriptor{
-		// FIXME: This should probably be configurable, so someone can specify
-		//        that a layer is not distributable.
-		MediaType: v1.MediaTypeImageLayer,
-		Digest:    layerDigest,
-		Size:      layerSize,
+	if val, ok := ctx.App.Metadata["--history.author"]; ok {
+		history.Author = val.(string)
+	}
+	if val, ok := ctx.App.Metadata["--history.comment"]; ok {
+		history.Comment = val.(string)
+	}
+	if val, ok := ctx.App.Metadata["--history.created"]; ok {
+		created, err := time.Parse(igen.ISO8601, val.(string))
+		if err != nil {
+			return errors.Wrap(err, "parsing --history.created")
+		}
+		history.Created = &created
+	}
+	if val, ok := ctx.App.Metadata["--history.created_by"]; ok {
+		history.CreatedBy = val.(string)
 	}
 
-	manifestBlob, err := cas.FromDescriptor(context.TODO(), engine, fromDescriptor)
+	mtreePath := filepath.Join(bundlePath, descriptorPaths[0].Descriptor().Digest.Encoded()+".mtree")
+	mfh, err := os.Open(mtreePath)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "open mtree")
 	}
-	defer manifestBlob.Close()
+	defer mfh.Close()
 
-	manifest, ok := manifestBlob.Data.(*v1.Manifest)
-	if !ok {
-		// Should never be reached.
-		return fmt.Errorf("manifest blob type not implemented: %s", manifestBlob.MediaType)
+	spec, err := mtree.ParseSpec(mfh)
+	if err != nil {
+		return errors.Wrap(err, "parse mtree")
 	}
 
-	// We also need to update the config. Fun.
-	configBlob, err := cas.FromDescriptor(context.TODO(), engine, &manifest.Config)
+	keywords := mtree.CollectUsedKeywords(spec)
+	diffs, err := mtree.Check(fullRootfsPath, spec, keywords)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "check mtree")
 	}
-	defer configBlob.Close()
 
-	config, ok := configBlob.Data.(*v1.Image)
-	if !ok {
-		// Should not be reached.
-		return fmt.Errorf("config blob type not implemented: %s", configBlob.MediaType)
+	addOptions, err := layerAddOptions(ctx)
+	if err != nil {
+		return err
 	}
-
-	g, err := generator.NewFromImage(*config)
+	addOptions.Annotations, err = layerAnnotations(ctx)
 	if err != nil {
 		return err
 	}
-
-	// Append our new layer to the set of DiffIDs.
-	g.AddRootfsDiffID(layerDigest)
-
-	// Update config and create a new blob for it.
-	*config = g.Image()
-	newConfigDigest, newConfigSize, err := engine.PutBlobJSON(context.TODO(), config)
+	newAnnotations, err := manifestAnnotations(ctx)
 	if err != nil {
 		return err
 	}
 
-	// Update the manifest to include the new layer, and also point at the new
-	// config. Then create a new blob for it.
-	manifest.Layers = append(manifest.Layers, *layerDescriptor)
-	manifest.Config.Digest = newConfigDigest
-	manifest.Config.Size = newConfigSize
-	newManifestDigest, newManifestSize, err := engine.PutBlobJSON(context.TODO(), manifest)
+	newDescriptors := make([]ispec.Descriptor, len(descriptorPaths))
+	for i, descriptorPath := range descriptorPaths {
+		mutator, err := mutate.New(engineExt, descriptorPath)
+		if err != nil {
+			return errors.Wrap(err, "create mutator for base image")
+		}
 
-	// Now create a new reference, and either add it to the engine or spew it
-	// to stdout.
+		reader := layer.GenerateLayer(fullRootfsPath, diffs, &meta.MapOptions)
 
-	newDescriptor := &v1.Descriptor{
-		// FIXME: Support manifest lists.
-		MediaType: v1.MediaTypeImageManifest,
-		Digest:    newManifestDigest,
-		Size:      newManifestSize,
-	}
+		addErr := mutator.Add(context.Background(), reader, history, addOptions)
+		reader.Close()
+		if addErr != nil {
+			return errors.Wrap(addErr, "add diff layer")
+		}
 
-	logrus.WithFields(logrus.Fields{
-		"mediatype": newDescriptor.MediaType,
-		"digest":    newDescriptor.Digest,
-		"size":      newDescriptor.Size,
-	}).Infof("created new image")
+		newDescriptorPath, err := mutator.Commit(context.Background(), newAnnotations)
+		if err != nil {
+			return errors.Wrap(err, "commit mutated image")
+		}
+		newDescriptors[i] = newDescriptorPath.Descriptor()
 
-	tagName := ctx.String("tag")
-	if tagName == "" {
-		return nil
+		log.Infof("new image manifest created: %s->%s", descriptorPath.Descriptor().Digest, newDescriptorPath.Descriptor().Digest)
 	}
 
-	// We have to clobber the old reference.
-	// XXX: Should we output some warning if we actually did remove an old
-	//      reference?
-	if err := engine.DeleteReference(context.TODO(), tagName); err != nil {
-		return err
-	}
-	if err := engine.PutReference(context.TODO(), tagName, newDescriptor); err != nil {
-		return err
+	newRoot, err := updateIndexEntries(context.Background(), engineExt, descriptorPaths, newDescriptors)
+	if err != nil {
+		return errors.Wrap(err, "update index")
 	}
 
+	if err := engineExt.UpdateReference(context.Background(), tagName, newRoot); err != nil {
+		return errors.Wrap(err, "add new tag")
+	}
+	log.Infof("created new tag for repacked image: %s", tagName)
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,174 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package mutate implements the ability to create new, modified images from
+// an existing image, by adding layers, changing history and so on, without
+// requiring the caller to understand the details of how OCI manifests and
+// configurations fit together.
+package mutate
+
+import (
+	"context"
+	"io"
+
+	"github.com/openSUSE/umoci/oci/casext"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Mutator is a builder for creating a new, modified manifest based on an
+// existing manifest. Mutator always operates on a single v1.Manifest --
+// callers that need to deal with an image index are responsible for
+// resolving the manifest they want to mutate (see casext.ResolveReference)
+// and for folding the result back into the index afterwards.
+type Mutator struct {
+	engine casext.Engine
+
+	from     casext.DescriptorPath
+	manifest ispec.Manifest
+	config   ispec.Image
+}
+
+// New creates a new Mutator for the manifest at the end of the given
+// descriptor path. from.Descriptor().MediaType must be
+// v1.MediaTypeImageManifest. If engine has a Cache attached, it is
+// transparently consulted (and populated) while reading the manifest and
+// config, rather than re-parsing them from disk every time.
+func New(engine casext.Engine, from casext.DescriptorPath) (*Mutator, error) {
+	descriptor := from.Descriptor()
+	if descriptor.MediaType != ispec.MediaTypeImageManifest {
+		return nil, errors.Errorf("new mutator: descriptor does not point to a manifest: %s", descriptor.MediaType)
+	}
+
+	manifestBlob, err := engine.FromDescriptor(context.Background(), descriptor)
+	if err != nil {
+		return nil, errors.Wrap(err, "get manifest")
+	}
+	defer manifestBlob.Close()
+
+	manifest, ok := manifestBlob.Data.(ispec.Manifest)
+	if !ok {
+		return nil, errors.Errorf("manifest blob type not implemented: %s", manifestBlob.MediaType)
+	}
+
+	configBlob, err := engine.FromDescriptor(context.Background(), manifest.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "get config")
+	}
+	defer configBlob.Close()
+
+	config, ok := configBlob.Data.(ispec.Image)
+	if !ok {
+		return nil, errors.Errorf("config blob type not implemented: %s", configBlob.MediaType)
+	}
+
+	return &Mutator{
+		engine:   engine,
+		from:     from,
+		manifest: manifest,
+		config:   config,
+	}, nil
+}
+
+// AddOptions customises the layer descriptor produced by Mutator.Add, for
+// the cases where the default (a plain, distributable
+// v1.MediaTypeImageLayer) isn't right.
+type AddOptions struct {
+	// MediaType overrides the media type of the new layer descriptor. If
+	// empty, ispec.MediaTypeImageLayer is used.
+	MediaType string
+
+	// URLs, if non-empty, populates the new layer descriptor's URLs field,
+	// as used by non-distributable layers to point at out-of-band content.
+	URLs []string
+
+	// Annotations, if non-empty, populates the new layer descriptor's
+	// Annotations field.
+	Annotations map[string]string
+}
+
+// Add adds a new layer (read from the given reader, which should produce an
+// uncompressed tar stream) to the image, recording history as provided.
+func (m *Mutator) Add(ctx context.Context, reader io.Reader, history ispec.History, opts AddOptions) error {
+	layerDigest, layerSize, err := m.engine.PutBlob(ctx, reader)
+	if err != nil {
+		return errors.Wrap(err, "put layer blob")
+	}
+
+	mediaType := opts.MediaType
+	if mediaType == "" {
+		mediaType = ispec.MediaTypeImageLayer
+	}
+
+	m.manifest.Layers = append(m.manifest.Layers, ispec.Descriptor{
+		MediaType:   mediaType,
+		Digest:      layerDigest,
+		Size:        layerSize,
+		URLs:        opts.URLs,
+		Annotations: opts.Annotations,
+	})
+
+	m.config.RootFS.Type = "layers"
+	m.config.RootFS.DiffIDs = append(m.config.RootFS.DiffIDs, layerDigest)
+
+	history.EmptyLayer = false
+	m.config.History = append(m.config.History, history)
+	return nil
+}
+
+// Commit writes out the new config and manifest produced by the mutations
+// applied so far, and returns the descriptor path of the new manifest. Note
+// that the returned path is always a single-element path -- if the original
+// reference resolved through an image index, it is the caller's
+// responsibility to fold the new manifest back into that index.
+//
+// annotations, if non-empty, are merged into the new manifest's
+// Annotations (overwriting any existing keys of the same name).
+func (m *Mutator) Commit(ctx context.Context, annotations map[string]string) (casext.DescriptorPath, error) {
+	if len(annotations) > 0 {
+		if m.manifest.Annotations == nil {
+			m.manifest.Annotations = map[string]string{}
+		}
+		for key, value := range annotations {
+			m.manifest.Annotations[key] = value
+		}
+	}
+
+	configDigest, configSize, err := m.engine.PutBlobJSON(ctx, m.config)
+	if err != nil {
+		return casext.DescriptorPath{}, errors.Wrap(err, "put config blob")
+	}
+
+	m.manifest.Config = ispec.Descriptor{
+		MediaType: ispec.MediaTypeImageConfig,
+		Digest:    configDigest,
+		Size:      configSize,
+	}
+
+	manifestDigest, manifestSize, err := m.engine.PutBlobJSON(ctx, m.manifest)
+	if err != nil {
+		return casext.DescriptorPath{}, errors.Wrap(err, "put manifest blob")
+	}
+
+	newDescriptor := ispec.Descriptor{
+		MediaType: ispec.MediaTypeImageManifest,
+		Digest:    manifestDigest,
+		Size:      manifestSize,
+	}
+
+	return casext.DescriptorPath{Walk: []ispec.Descriptor{newDescriptor}}, nil
+}
@@ -0,0 +1,147 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package cache implements an on-disk memoisation layer for the decoded
+// manifests and image configs that umoci repeatedly re-parses when a
+// scripted pipeline runs several umoci invocations against the same image
+// (insert, then repack, then insert again, and so on). It is intentionally
+// not a cas.Engine itself -- it stores already-decoded Go structs rather
+// than blobs -- so that callers such as casext.Engine can consult it before
+// paying the cost of fetching and unmarshalling a blob from the underlying
+// cas.Engine.
+package cache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	digest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// DBName is the name of the cache database file that is stored alongside
+// an image's blobs/ directory.
+const DBName = "cache.db"
+
+var (
+	manifestBucket = []byte("manifests")
+	configBucket   = []byte("configs")
+)
+
+// Store is an on-disk cache of decoded manifests and configs, backed by a
+// bbolt database.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens (creating if necessary) the cache database for the image
+// rooted at imagePath.
+func Open(imagePath string) (*Store, error) {
+	db, err := bolt.Open(filepath.Join(imagePath, DBName), 0644, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "open cache db")
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{manifestBucket, configBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "initialise cache db")
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close releases the underlying cache database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func get(db *bolt.DB, bucket []byte, key digest.Digest, out interface{}) (bool, error) {
+	var raw []byte
+	err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucket).Get([]byte(key.String())); v != nil {
+			raw = append(raw, v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	if raw == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return false, errors.Wrap(err, "unmarshal cache entry")
+	}
+	return true, nil
+}
+
+func put(db *bolt.DB, bucket []byte, key digest.Digest, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return errors.Wrap(err, "marshal cache entry")
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key.String()), raw)
+	})
+}
+
+// GetManifest returns the decoded manifest cached for the given digest, and
+// whether it was found.
+func (s *Store) GetManifest(blobDigest digest.Digest) (ispec.Manifest, bool, error) {
+	var manifest ispec.Manifest
+	ok, err := get(s.db, manifestBucket, blobDigest, &manifest)
+	return manifest, ok, err
+}
+
+// PutManifest caches the decoded manifest for the given digest.
+func (s *Store) PutManifest(blobDigest digest.Digest, manifest ispec.Manifest) error {
+	return put(s.db, manifestBucket, blobDigest, manifest)
+}
+
+// GetConfig returns the decoded image config cached for the given digest,
+// and whether it was found.
+func (s *Store) GetConfig(blobDigest digest.Digest) (ispec.Image, bool, error) {
+	var config ispec.Image
+	ok, err := get(s.db, configBucket, blobDigest, &config)
+	return config, ok, err
+}
+
+// PutConfig caches the decoded image config for the given digest.
+func (s *Store) PutConfig(blobDigest digest.Digest, config ispec.Image) error {
+	return put(s.db, configBucket, blobDigest, config)
+}
+
+// Remove deletes the cache database for the image rooted at imagePath, if
+// one exists.
+func Remove(imagePath string) error {
+	err := os.Remove(filepath.Join(imagePath, DBName))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "remove cache db")
+	}
+	return nil
+}
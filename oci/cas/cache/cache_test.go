@@ -0,0 +1,132 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func tempImagePath(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "umoci-cache-test")
+	if err != nil {
+		t.Fatalf("create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestStoreManifestRoundTrip(t *testing.T) {
+	imagePath := tempImagePath(t)
+
+	store, err := Open(imagePath)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+
+	if _, ok := fileExists(t, imagePath); !ok {
+		t.Errorf("Open did not create %s", DBName)
+	}
+
+	blobDigest := digest.FromString("some manifest")
+	if _, ok, err := store.GetManifest(blobDigest); err != nil || ok {
+		t.Fatalf("GetManifest on empty store = (_, %v, %v), want (_, false, nil)", ok, err)
+	}
+
+	manifest := ispec.Manifest{
+		Config: ispec.Descriptor{MediaType: ispec.MediaTypeImageConfig, Digest: blobDigest},
+	}
+	if err := store.PutManifest(blobDigest, manifest); err != nil {
+		t.Fatalf("PutManifest: %v", err)
+	}
+
+	got, ok, err := store.GetManifest(blobDigest)
+	if err != nil || !ok {
+		t.Fatalf("GetManifest after put = (_, %v, %v), want (_, true, nil)", ok, err)
+	}
+	if got.Config.Digest != manifest.Config.Digest {
+		t.Errorf("GetManifest round-trip = %+v, want %+v", got, manifest)
+	}
+}
+
+func TestStoreConfigRoundTrip(t *testing.T) {
+	imagePath := tempImagePath(t)
+
+	store, err := Open(imagePath)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer store.Close()
+
+	blobDigest := digest.FromString("some config")
+	if _, ok, err := store.GetConfig(blobDigest); err != nil || ok {
+		t.Fatalf("GetConfig on empty store = (_, %v, %v), want (_, false, nil)", ok, err)
+	}
+
+	config := ispec.Image{Architecture: "amd64", OS: "linux"}
+	if err := store.PutConfig(blobDigest, config); err != nil {
+		t.Fatalf("PutConfig: %v", err)
+	}
+
+	got, ok, err := store.GetConfig(blobDigest)
+	if err != nil || !ok {
+		t.Fatalf("GetConfig after put = (_, %v, %v), want (_, true, nil)", ok, err)
+	}
+	if got.Architecture != config.Architecture || got.OS != config.OS {
+		t.Errorf("GetConfig round-trip = %+v, want %+v", got, config)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	imagePath := tempImagePath(t)
+
+	store, err := Open(imagePath)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	store.Close()
+
+	if err := Remove(imagePath); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(imagePath, DBName)); !os.IsNotExist(err) {
+		t.Errorf("Remove left %s behind: %v", DBName, err)
+	}
+
+	// Removing an already-removed cache is a no-op, not an error.
+	if err := Remove(imagePath); err != nil {
+		t.Errorf("Remove on already-removed cache: %v", err)
+	}
+}
+
+func fileExists(t *testing.T, imagePath string) (os.FileInfo, bool) {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(imagePath, DBName))
+	if err != nil {
+		return nil, false
+	}
+	return info, true
+}
@@ -0,0 +1,77 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package cas defines the content-addressable-storage primitives that umoci
+// uses to read and write OCI images, independent of how the blobs and
+// references are actually persisted (a local directory, a future remote
+// store, and so on).
+package cas
+
+import (
+	"context"
+	"io"
+
+	digest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Engine is the interface that any blob store which umoci can use as the
+// backing store for an OCI image must implement. Implementations do not
+// need to be safe against concurrent modification from multiple processes,
+// but must be safe against concurrent readers.
+type Engine interface {
+	// Close releases all resources held by the engine. Subsequent calls to
+	// the engine are not valid once this is called.
+	Close() error
+
+	// Path returns the root path of the engine, for diagnostic purposes.
+	Path() string
+
+	// GetBlob returns a reader for retrieving a blob from the image's blob
+	// store, given its digest.
+	GetBlob(ctx context.Context, digest digest.Digest) (io.ReadCloser, error)
+
+	// PutBlob adds a new blob to the image's blob store, returning its
+	// digest and size.
+	PutBlob(ctx context.Context, reader io.Reader) (digest.Digest, int64, error)
+
+	// PutBlobJSON adds a new JSON blob to the image's blob store,
+	// marshalling the provided struct, and returns its digest and size.
+	PutBlobJSON(ctx context.Context, data interface{}) (digest.Digest, int64, error)
+
+	// DeleteBlob removes a blob from the image's blob store. DeleteBlob is
+	// idempotent; deleting a non-existent blob does not return an error.
+	DeleteBlob(ctx context.Context, digest digest.Digest) error
+
+	// ListBlobs returns the set of blob digests stored in the image.
+	ListBlobs(ctx context.Context) ([]digest.Digest, error)
+
+	// GetReference resolves a reference (tag) name to the descriptor that it
+	// currently points to.
+	GetReference(ctx context.Context, name string) (ispec.Descriptor, error)
+
+	// PutReference sets a reference to point to the given descriptor,
+	// overwriting any existing reference with the same name.
+	PutReference(ctx context.Context, name string, descriptor ispec.Descriptor) error
+
+	// DeleteReference removes a reference. DeleteReference is idempotent;
+	// deleting a non-existent reference does not return an error.
+	DeleteReference(ctx context.Context, name string) error
+
+	// ListReferences returns the set of reference names stored in the image.
+	ListReferences(ctx context.Context) ([]string, error)
+}
@@ -0,0 +1,246 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package dir implements a cas.Engine on top of a plain directory laid out
+// according to the OCI image-layout specification (oci-layout, index.json
+// and a blobs/<algorithm>/<encoded> content store).
+package dir
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/openSUSE/umoci/oci/cas"
+	digest "github.com/opencontainers/go-digest"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// blobDirectory is the directory inside the OCI image that contains all
+	// of the blobs.
+	blobDirectory = "blobs"
+
+	// indexPath is the path inside the OCI image that contains the top-level
+	// index.
+	indexPath = "index.json"
+
+	// layoutFile is the path inside the OCI image that contains the
+	// oci-layout.
+	layoutFile = "oci-layout"
+)
+
+// dirEngine is a cas.Engine backed by a plain directory tree.
+type dirEngine struct {
+	path string
+}
+
+// Open opens a new reference to the directory-backed CAS engine rooted at
+// the given path. The directory must already contain a valid OCI
+// image-layout (use Create to initialise a new one).
+func Open(path string) (cas.Engine, error) {
+	if _, err := os.Stat(filepath.Join(path, layoutFile)); err != nil {
+		return nil, errors.Wrap(err, "open image")
+	}
+	if _, err := os.Stat(filepath.Join(path, indexPath)); err != nil {
+		return nil, errors.Wrap(err, "open image")
+	}
+	return &dirEngine{path: path}, nil
+}
+
+// Create initialises a new, empty OCI image-layout at the given path.
+func Create(path string) error {
+	if err := os.MkdirAll(filepath.Join(path, blobDirectory), 0755); err != nil {
+		return errors.Wrap(err, "mkdir blobs")
+	}
+
+	layout := ispec.ImageLayout{Version: ispec.ImageLayoutVersion}
+	if err := writeJSON(filepath.Join(path, layoutFile), layout); err != nil {
+		return errors.Wrap(err, "write oci-layout")
+	}
+
+	index := ispec.Index{}
+	if err := writeJSON(filepath.Join(path, indexPath), index); err != nil {
+		return errors.Wrap(err, "write index.json")
+	}
+	return nil
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func (e *dirEngine) Close() error { return nil }
+
+func (e *dirEngine) Path() string { return e.path }
+
+func (e *dirEngine) blobPath(digest digest.Digest) string {
+	return filepath.Join(e.path, blobDirectory, digest.Algorithm().String(), digest.Encoded())
+}
+
+func (e *dirEngine) GetBlob(ctx context.Context, digest digest.Digest) (io.ReadCloser, error) {
+	fh, err := os.Open(e.blobPath(digest))
+	if err != nil {
+		return nil, errors.Wrap(err, "get blob")
+	}
+	return fh, nil
+}
+
+func (e *dirEngine) PutBlob(ctx context.Context, reader io.Reader) (digest.Digest, int64, error) {
+	tmp, err := ioutil.TempFile(e.path, "blob-")
+	if err != nil {
+		return "", 0, errors.Wrap(err, "create temp blob")
+	}
+	defer tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	digester := digest.Canonical.Digester()
+	size, err := io.Copy(io.MultiWriter(tmp, digester.Hash()), reader)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "copy blob")
+	}
+
+	blobDigest := digester.Digest()
+	if err := os.MkdirAll(filepath.Dir(e.blobPath(blobDigest)), 0755); err != nil {
+		return "", 0, errors.Wrap(err, "mkdir blob algorithm")
+	}
+	if err := os.Rename(tmp.Name(), e.blobPath(blobDigest)); err != nil {
+		return "", 0, errors.Wrap(err, "rename temp blob")
+	}
+	return blobDigest, size, nil
+}
+
+func (e *dirEngine) PutBlobJSON(ctx context.Context, data interface{}) (digest.Digest, int64, error) {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "marshal json blob")
+	}
+	return e.PutBlob(ctx, bytes.NewReader(buf))
+}
+
+func (e *dirEngine) DeleteBlob(ctx context.Context, digest digest.Digest) error {
+	if err := os.Remove(e.blobPath(digest)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "delete blob")
+	}
+	return nil
+}
+
+func (e *dirEngine) ListBlobs(ctx context.Context) ([]digest.Digest, error) {
+	var digests []digest.Digest
+	algos, err := ioutil.ReadDir(filepath.Join(e.path, blobDirectory))
+	if err != nil {
+		return nil, errors.Wrap(err, "list blob algorithms")
+	}
+	for _, algo := range algos {
+		entries, err := ioutil.ReadDir(filepath.Join(e.path, blobDirectory, algo.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "list blobs")
+		}
+		for _, entry := range entries {
+			digests = append(digests, digest.NewDigestFromEncoded(digest.Algorithm(algo.Name()), entry.Name()))
+		}
+	}
+	return digests, nil
+}
+
+func (e *dirEngine) getIndex() (ispec.Index, error) {
+	var index ispec.Index
+	raw, err := ioutil.ReadFile(filepath.Join(e.path, indexPath))
+	if err != nil {
+		return index, errors.Wrap(err, "read index.json")
+	}
+	if err := json.Unmarshal(raw, &index); err != nil {
+		return index, errors.Wrap(err, "unmarshal index.json")
+	}
+	return index, nil
+}
+
+func (e *dirEngine) putIndex(index ispec.Index) error {
+	return writeJSON(filepath.Join(e.path, indexPath), index)
+}
+
+func (e *dirEngine) GetReference(ctx context.Context, name string) (ispec.Descriptor, error) {
+	index, err := e.getIndex()
+	if err != nil {
+		return ispec.Descriptor{}, err
+	}
+	for _, descriptor := range index.Manifests {
+		if descriptor.Annotations[ispec.AnnotationRefName] == name {
+			return descriptor, nil
+		}
+	}
+	return ispec.Descriptor{}, errors.Errorf("reference not found: %s", name)
+}
+
+func (e *dirEngine) PutReference(ctx context.Context, name string, descriptor ispec.Descriptor) error {
+	index, err := e.getIndex()
+	if err != nil {
+		return err
+	}
+
+	if descriptor.Annotations == nil {
+		descriptor.Annotations = map[string]string{}
+	}
+	descriptor.Annotations[ispec.AnnotationRefName] = name
+
+	var manifests []ispec.Descriptor
+	for _, existing := range index.Manifests {
+		if existing.Annotations[ispec.AnnotationRefName] != name {
+			manifests = append(manifests, existing)
+		}
+	}
+	index.Manifests = append(manifests, descriptor)
+	return e.putIndex(index)
+}
+
+func (e *dirEngine) DeleteReference(ctx context.Context, name string) error {
+	index, err := e.getIndex()
+	if err != nil {
+		return err
+	}
+	var manifests []ispec.Descriptor
+	for _, existing := range index.Manifests {
+		if existing.Annotations[ispec.AnnotationRefName] != name {
+			manifests = append(manifests, existing)
+		}
+	}
+	index.Manifests = manifests
+	return e.putIndex(index)
+}
+
+func (e *dirEngine) ListReferences(ctx context.Context) ([]string, error) {
+	index, err := e.getIndex()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, descriptor := range index.Manifests {
+		if name, ok := descriptor.Annotations[ispec.AnnotationRefName]; ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,129 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package casext
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Blob represents a blob that has been parsed out of the CAS, with its
+// descriptor and decoded contents available to the caller. Data's concrete
+// type depends on Descriptor.MediaType: ispec.Index for an image index,
+// ispec.Manifest for an image manifest, and ispec.Image for an image
+// config.
+type Blob struct {
+	// Descriptor is the descriptor that was used to fetch this blob.
+	Descriptor ispec.Descriptor
+
+	// MediaType is a convenience alias for Descriptor.MediaType.
+	MediaType string
+
+	// Data is the parsed contents of the blob.
+	Data interface{}
+}
+
+// Close is a no-op, kept so that callers can unconditionally
+// "defer blob.Close()" without caring whether Data came from the cache or
+// from the underlying cas.Engine. FromDescriptor always fully reads and
+// closes the underlying blob reader itself before returning.
+func (b *Blob) Close() error {
+	return nil
+}
+
+// FromDescriptor fetches and parses the blob referred to by the given
+// descriptor, choosing the Go type to unmarshal into based on its
+// MediaType. If the engine has a Cache attached and already has a decoded
+// manifest or config for this digest, the cached copy is returned without
+// touching the underlying cas.Engine at all.
+func (e Engine) FromDescriptor(ctx context.Context, descriptor ispec.Descriptor) (*Blob, error) {
+	if e.Cache != nil {
+		switch descriptor.MediaType {
+		case ispec.MediaTypeImageManifest:
+			if manifest, ok, err := e.Cache.GetManifest(descriptor.Digest); err != nil {
+				return nil, errors.Wrap(err, "get cached manifest")
+			} else if ok {
+				return &Blob{Descriptor: descriptor, MediaType: descriptor.MediaType, Data: manifest}, nil
+			}
+		case ispec.MediaTypeImageConfig:
+			if config, ok, err := e.Cache.GetConfig(descriptor.Digest); err != nil {
+				return nil, errors.Wrap(err, "get cached config")
+			} else if ok {
+				return &Blob{Descriptor: descriptor, MediaType: descriptor.MediaType, Data: config}, nil
+			}
+		}
+	}
+
+	reader, err := e.GetBlob(ctx, descriptor.Digest)
+	if err != nil {
+		return nil, errors.Wrap(err, "get blob")
+	}
+	defer reader.Close()
+
+	blob := &Blob{
+		Descriptor: descriptor,
+		MediaType:  descriptor.MediaType,
+	}
+
+	raw, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "read blob")
+	}
+
+	switch descriptor.MediaType {
+	case ispec.MediaTypeImageIndex:
+		var index ispec.Index
+		if err := json.Unmarshal(raw, &index); err != nil {
+			return nil, errors.Wrap(err, "unmarshal index")
+		}
+		blob.Data = index
+
+	case ispec.MediaTypeImageManifest:
+		var manifest ispec.Manifest
+		if err := json.Unmarshal(raw, &manifest); err != nil {
+			return nil, errors.Wrap(err, "unmarshal manifest")
+		}
+		blob.Data = manifest
+		if e.Cache != nil {
+			if err := e.Cache.PutManifest(descriptor.Digest, manifest); err != nil {
+				return nil, errors.Wrap(err, "cache manifest")
+			}
+		}
+
+	case ispec.MediaTypeImageConfig:
+		var config ispec.Image
+		if err := json.Unmarshal(raw, &config); err != nil {
+			return nil, errors.Wrap(err, "unmarshal config")
+		}
+		blob.Data = config
+		if e.Cache != nil {
+			if err := e.Cache.PutConfig(descriptor.Digest, config); err != nil {
+				return nil, errors.Wrap(err, "cache config")
+			}
+		}
+
+	default:
+		return nil, errors.Errorf("unsupported blob media-type: %s", descriptor.MediaType)
+	}
+
+	return blob, nil
+}
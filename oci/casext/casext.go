@@ -0,0 +1,160 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package casext provides higher-level helpers on top of a cas.Engine that
+// understand OCI concepts -- resolving references, walking manifest lists
+// (image indexes) down to a concrete manifest, and so on -- which are too
+// specific to be part of the core blob-store interface.
+package casext
+
+import (
+	"context"
+
+	"github.com/openSUSE/umoci/oci/cas"
+	"github.com/openSUSE/umoci/oci/cas/cache"
+	ispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// Engine wraps a cas.Engine with the higher-level helpers in this package.
+type Engine struct {
+	cas.Engine
+
+	// Cache, if non-nil, is consulted by FromDescriptor before fetching and
+	// decoding a manifest or config blob from the underlying cas.Engine,
+	// and is populated with the result afterwards. It is not set by
+	// NewEngine -- callers that want caching must set it explicitly with
+	// SetCache.
+	Cache *cache.Store
+}
+
+// NewEngine wraps the given cas.Engine so that the casext helpers are
+// available on it.
+func NewEngine(engine cas.Engine) Engine {
+	return Engine{Engine: engine}
+}
+
+// SetCache returns a copy of the engine with the given manifest/config cache
+// attached. The receiver itself is not modified, so callers must use the
+// returned Engine.
+func (e Engine) SetCache(store *cache.Store) Engine {
+	e.Cache = store
+	return e
+}
+
+// DescriptorPath represents the set of descriptors you have to walk through
+// in order to get to a particular descriptor, starting from the reference
+// (and thus the root of the image -- which may be an image index). Walk[0]
+// is always the root descriptor, and Walk[len(Walk)-1] is the descriptor
+// that the path actually refers to.
+type DescriptorPath struct {
+	// Walk is the list of descriptors, starting at the root of the image
+	// and ending at the descriptor this path refers to.
+	Walk []ispec.Descriptor
+}
+
+// Root returns the descriptor at the root of this path (the one stored
+// directly in the image's reference).
+func (d DescriptorPath) Root() ispec.Descriptor {
+	return d.Walk[0]
+}
+
+// Descriptor returns the descriptor that this path refers to (the last
+// element of the walk).
+func (d DescriptorPath) Descriptor() ispec.Descriptor {
+	return d.Walk[len(d.Walk)-1]
+}
+
+// append returns a new DescriptorPath with descriptor appended to the walk.
+func (d DescriptorPath) append(descriptor ispec.Descriptor) DescriptorPath {
+	walk := make([]ispec.Descriptor, len(d.Walk)+1)
+	copy(walk, d.Walk)
+	walk[len(walk)-1] = descriptor
+	return DescriptorPath{Walk: walk}
+}
+
+// ResolveReference resolves the given reference name to the set of
+// manifests (v1.MediaTypeImageManifest) it refers to. If the reference
+// points to a single manifest, a single DescriptorPath is returned. If the
+// reference points to an image index, ResolveReference walks through the
+// index and returns one DescriptorPath per child manifest it contains --
+// callers that cannot deal with more than one result (because they don't
+// understand image indexes) should treat len(paths) != 1 as an ambiguous
+// reference.
+func (e Engine) ResolveReference(ctx context.Context, name string) ([]DescriptorPath, error) {
+	root, err := e.GetReference(ctx, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "get reference")
+	}
+	return e.walkDescriptor(ctx, DescriptorPath{Walk: []ispec.Descriptor{root}})
+}
+
+// walkDescriptor expands the final descriptor in the given path into the set
+// of manifest descriptor-paths it represents. Only a single level of image
+// index is supported: an index whose Manifests contains another index is
+// rejected outright, rather than silently flattened, since callers such as
+// updateIndexEntries only ever rewrite the root index and have no way to
+// fold a change back through more than one level of nesting.
+func (e Engine) walkDescriptor(ctx context.Context, path DescriptorPath) ([]DescriptorPath, error) {
+	descriptor := path.Descriptor()
+
+	switch descriptor.MediaType {
+	case ispec.MediaTypeImageManifest:
+		return []DescriptorPath{path}, nil
+
+	case ispec.MediaTypeImageIndex:
+		if len(path.Walk) > 1 {
+			return nil, errors.Errorf("resolve reference: nested image indexes are not supported")
+		}
+
+		blob, err := e.FromDescriptor(ctx, descriptor)
+		if err != nil {
+			return nil, errors.Wrap(err, "get index")
+		}
+		defer blob.Close()
+
+		index, ok := blob.Data.(ispec.Index)
+		if !ok {
+			return nil, errors.Errorf("index blob type not implemented: %s", blob.MediaType)
+		}
+
+		var paths []DescriptorPath
+		for _, child := range index.Manifests {
+			childPaths, err := e.walkDescriptor(ctx, path.append(child))
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, childPaths...)
+		}
+		return paths, nil
+
+	default:
+		return nil, errors.Errorf("resolve reference: unsupported descriptor media-type: %s", descriptor.MediaType)
+	}
+}
+
+// UpdateReference replaces the reference called name (creating it if it
+// doesn't already exist) so that it points to the given root descriptor.
+func (e Engine) UpdateReference(ctx context.Context, name string, descriptor ispec.Descriptor) error {
+	if err := e.DeleteReference(ctx, name); err != nil {
+		return errors.Wrap(err, "delete old reference")
+	}
+	if err := e.PutReference(ctx, name, descriptor); err != nil {
+		return errors.Wrap(err, "put new reference")
+	}
+	return nil
+}
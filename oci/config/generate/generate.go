@@ -0,0 +1,25 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package generate defines shared conventions for building v1.Image
+// configurations, used by "umoci insert" and "umoci repack" when filling in
+// history entries.
+package generate
+
+// ISO8601 is the time format used for all timestamps umoci writes into
+// image configurations and history entries.
+const ISO8601 = "2006-01-02T15:04:05.999999999Z07:00"
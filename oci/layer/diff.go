@@ -0,0 +1,125 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package layer
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+	"github.com/vbatts/go-mtree"
+)
+
+// whiteoutPrefix is the AUFS-style whiteout prefix used to mark a path as
+// deleted inside an OCI layer.
+const whiteoutPrefix = ".wh."
+
+// opaqueWhiteoutName is the AUFS-style opaque whiteout marker used to mark
+// a directory as opaque -- hiding any lower-layer contents that aren't
+// explicitly re-added by this layer, without removing the directory entry
+// itself.
+const opaqueWhiteoutName = ".wh..wh..opq"
+
+// GenerateLayer creates a new diff layer from the set of mtree differences
+// found between a runtime bundle's rootfs (at root) and the mtree
+// specification it was unpacked with. Added and modified paths are added to
+// the layer as-is; deleted paths are represented as AUFS-style whiteout
+// entries. The returned reader produces an uncompressed tar stream and must
+// be Closed by the caller.
+func GenerateLayer(root string, diffs []mtree.InodeDelta, opts *MapOptions) io.ReadCloser {
+	pipeReader, pipeWriter := io.Pipe()
+
+	go func() {
+		pipeWriter.CloseWithError(errors.Wrap(generateLayer(pipeWriter, root, diffs, opts), "generate diff layer"))
+	}()
+
+	return pipeReader
+}
+
+func generateLayer(w io.Writer, root string, diffs []mtree.InodeDelta, opts *MapOptions) error {
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	for _, diff := range diffs {
+		name := diff.Path()
+
+		switch diff.Type() {
+		case mtree.Missing:
+			if err := addWhiteout(tw, name, opts); err != nil {
+				return errors.Wrapf(err, "add whiteout %q", name)
+			}
+
+		case mtree.Modified, mtree.Extra:
+			path := filepath.Join(root, name)
+			info, err := os.Lstat(path)
+			if err != nil {
+				return errors.Wrapf(err, "lstat %q", name)
+			}
+			if err := addFile(tw, path, name, info, opts); err != nil {
+				return errors.Wrapf(err, "add %q", name)
+			}
+
+		default:
+			log.WithFields(log.Fields{"path": name, "type": diff.Type()}).Debugf("layer: ignoring unchanged entry")
+		}
+	}
+
+	return nil
+}
+
+// addWhiteout writes a ".wh.<name>" entry to tw, recording the deletion of
+// name.
+func addWhiteout(tw *tar.Writer, name string, opts *MapOptions) error {
+	dir, base := filepath.Split(name)
+	whiteoutName := filepath.Join(dir, whiteoutPrefix+base)
+
+	uid, gid := mapHostToContainer(0, 0, opts)
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.ToSlash(whiteoutName),
+		Size:     0,
+		Mode:     0600,
+		Uid:      uid,
+		Gid:      gid,
+	}
+	return tw.WriteHeader(hdr)
+}
+
+// addOpaqueWhiteout writes a ".wh..wh..opq" marker inside name, recording
+// that name is now opaque. Unlike addWhiteout, it does not write a header
+// for name itself: name is assumed to already exist on disk (layer
+// application creates/updates parent directories as needed), and umoci has
+// no unpacked rootfs to stat its real mode/owner from here -- writing a
+// synthetic directory entry would silently reset them to a hardcoded
+// 0755/root:root as a side effect of marking it opaque.
+func addOpaqueWhiteout(tw *tar.Writer, name string, opts *MapOptions) error {
+	uid, gid := mapHostToContainer(0, 0, opts)
+
+	opqHdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.ToSlash(filepath.Join(name, opaqueWhiteoutName)),
+		Size:     0,
+		Mode:     0600,
+		Uid:      uid,
+		Gid:      gid,
+	}
+	return tw.WriteHeader(opqHdr)
+}
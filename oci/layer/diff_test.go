@@ -0,0 +1,92 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package layer
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func readEntries(t *testing.T, raw []byte) []*tar.Header {
+	t.Helper()
+
+	var hdrs []*tar.Header
+	tr := tar.NewReader(bytes.NewReader(raw))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read tar entry: %v", err)
+		}
+		hdrs = append(hdrs, hdr)
+	}
+	return hdrs
+}
+
+func TestAddWhiteout(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := addWhiteout(tw, "some/dir/file", nil); err != nil {
+		t.Fatalf("addWhiteout: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar writer: %v", err)
+	}
+
+	hdrs := readEntries(t, buf.Bytes())
+	if len(hdrs) != 1 {
+		t.Fatalf("addWhiteout wrote %d entries, want 1", len(hdrs))
+	}
+	if got, want := hdrs[0].Name, "some/dir/.wh.file"; got != want {
+		t.Errorf("addWhiteout entry name = %q, want %q", got, want)
+	}
+	if hdrs[0].Typeflag != tar.TypeReg || hdrs[0].Size != 0 {
+		t.Errorf("addWhiteout entry = %+v, want an empty regular file", hdrs[0])
+	}
+}
+
+// TestAddOpaqueWhiteout verifies that marking a directory opaque writes
+// only the ".wh..wh..opq" marker, and not a synthetic header for the
+// directory itself -- umoci has no unpacked rootfs to stat the real
+// directory's mode/owner from here, so writing one would silently reset
+// them rather than just hiding the lower layers' contents.
+func TestAddOpaqueWhiteout(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := addOpaqueWhiteout(tw, "some/dir", nil); err != nil {
+		t.Fatalf("addOpaqueWhiteout: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar writer: %v", err)
+	}
+
+	hdrs := readEntries(t, buf.Bytes())
+	if len(hdrs) != 1 {
+		t.Fatalf("addOpaqueWhiteout wrote %d entries, want 1 (no synthetic directory entry)", len(hdrs))
+	}
+	if got, want := hdrs[0].Name, "some/dir/.wh..wh..opq"; got != want {
+		t.Errorf("addOpaqueWhiteout entry name = %q, want %q", got, want)
+	}
+	if hdrs[0].Typeflag != tar.TypeReg || hdrs[0].Size != 0 {
+		t.Errorf("addOpaqueWhiteout entry = %+v, want an empty regular file", hdrs[0])
+	}
+}
@@ -0,0 +1,170 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package layer implements the generation and application of OCI diff
+// layers -- turning a directory (or other source) on disk into a tar stream
+// suitable for use as a layer blob, and vice versa.
+package layer
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+)
+
+// Operation describes what GenerateInsertLayer should do with <target>:
+// add new content at that path, or remove content that's already there.
+type Operation int
+
+const (
+	// OpAdd adds the contents of root (a file or, recursively, a
+	// directory) at target.
+	OpAdd Operation = iota
+
+	// OpWhiteout removes target, by writing an AUFS-style ".wh.<name>"
+	// whiteout entry in its parent directory. If target was a directory,
+	// this removes it (and everything inside it) entirely.
+	OpWhiteout
+
+	// OpOpaqueWhiteout marks target (which must be a directory) as
+	// opaque, by writing a ".wh..wh..opq" marker inside it. Unlike
+	// OpWhiteout, the directory entry itself is preserved -- only the
+	// lower layers' view of its previous contents is hidden.
+	OpOpaqueWhiteout
+)
+
+// GenerateInsertLayer creates a new diff layer that applies the given
+// operation at target inside the image filesystem. For OpAdd, root is the
+// file or directory (insert is automatically recursive for directories) to
+// add; it is ignored for the whiteout operations. The returned reader
+// produces an uncompressed tar stream; the caller is responsible for
+// compressing it if required, and must Close the reader once done.
+func GenerateInsertLayer(op Operation, root string, target string, opts *MapOptions) io.ReadCloser {
+	pipeReader, pipeWriter := io.Pipe()
+
+	go func() {
+		pipeWriter.CloseWithError(errors.Wrap(generateInsertLayer(pipeWriter, op, root, target, opts), "generate insert layer"))
+	}()
+
+	return pipeReader
+}
+
+// generateInsertLayer does the actual work of producing the tar stream for
+// the requested operation.
+func generateInsertLayer(w io.Writer, op Operation, root string, target string, opts *MapOptions) error {
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	switch op {
+	case OpWhiteout:
+		return addWhiteout(tw, target, opts)
+
+	case OpOpaqueWhiteout:
+		return addOpaqueWhiteout(tw, target, opts)
+
+	case OpAdd:
+		info, err := os.Lstat(root)
+		if err != nil {
+			return errors.Wrap(err, "lstat root")
+		}
+
+		if !info.IsDir() {
+			return addFile(tw, root, target, info, opts)
+		}
+
+		return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return errors.Wrap(err, "compute relative path")
+			}
+			name := target
+			if rel != "." {
+				name = filepath.Join(target, rel)
+			}
+			return addFile(tw, path, name, info, opts)
+		})
+
+	default:
+		return errors.Errorf("generate insert layer: unknown operation: %d", op)
+	}
+}
+
+// addFile writes a single tar entry for path (named name inside the
+// archive) to tw, translating its owner through opts.
+func addFile(tw *tar.Writer, path string, name string, info os.FileInfo, opts *MapOptions) error {
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return errors.Wrap(err, "readlink")
+		}
+		link = target
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return errors.Wrap(err, "convert file info to tar header")
+	}
+	hdr.Name = filepath.ToSlash(name)
+
+	hdr.Uid, hdr.Gid = mapHostToContainer(hdr.Uid, hdr.Gid, opts)
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return errors.Wrap(err, "write tar header")
+	}
+
+	if info.Mode().IsRegular() {
+		fh, err := os.Open(path)
+		if err != nil {
+			return errors.Wrap(err, "open file")
+		}
+		defer fh.Close()
+
+		if _, err := io.Copy(tw, fh); err != nil {
+			return errors.Wrap(err, "write tar contents")
+		}
+	}
+
+	log.WithFields(log.Fields{"name": hdr.Name}).Debugf("layer: added entry")
+	return nil
+}
+
+// mapHostToContainer translates a host UID/GID pair into the container's ID
+// space using the given MapOptions. If opts is nil, or no mapping applies,
+// the IDs are passed through unchanged.
+func mapHostToContainer(uid, gid int, opts *MapOptions) (int, int) {
+	if opts == nil {
+		return uid, gid
+	}
+	return mapID(uid, opts.UIDMappings), mapID(gid, opts.GIDMappings)
+}
+
+func mapID(id int, mappings []IDMap) int {
+	for _, m := range mappings {
+		if id >= m.HostID && id < m.HostID+m.Size {
+			return m.ContainerID + (id - m.HostID)
+		}
+	}
+	return id
+}
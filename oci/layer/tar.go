@@ -0,0 +1,174 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package layer
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apex/log"
+	"github.com/pkg/errors"
+)
+
+// gzipMagic is the two-byte magic number that prefixes a gzip stream.
+var gzipMagic = [2]byte{0x1f, 0x8b}
+
+// IsTarball returns whether the file at path looks like a tar archive
+// (optionally gzip-compressed), by sniffing its contents. Directories and
+// files that don't parse as a tar stream return false, with no error.
+func IsTarball(path string) (bool, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false, errors.Wrap(err, "lstat")
+	}
+	if info.IsDir() {
+		return false, nil
+	}
+
+	fh, err := os.Open(path)
+	if err != nil {
+		return false, errors.Wrap(err, "open")
+	}
+	defer fh.Close()
+
+	reader, err := tarDecompressor(fh)
+	if err != nil {
+		// Not even a valid gzip stream.
+		return false, nil
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	_, err = tar.NewReader(reader).Next()
+	return err == nil, nil
+}
+
+// tarDecompressor wraps fh in a gzip.Reader if it looks gzip-compressed,
+// otherwise it is returned unchanged.
+func tarDecompressor(fh *os.File) (io.Reader, error) {
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(fh, magic); err != nil {
+		if _, serr := fh.Seek(0, io.SeekStart); serr != nil {
+			return nil, serr
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fh, nil
+		}
+		return nil, err
+	}
+	if _, err := fh.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if magic[0] == gzipMagic[0] && magic[1] == gzipMagic[1] {
+		return gzip.NewReader(fh)
+	}
+	return fh, nil
+}
+
+// GenerateInsertLayerFromTar is a sibling of GenerateInsertLayer that reads
+// its source as a tar (or tar.gz) stream instead of walking a directory on
+// disk, splatting each entry at target rather than extracting it to a
+// scratch directory first. This is what "umoci insert" uses when <file> is
+// a tarball, e.g. a rootfs snapshot or a language-specific build artifact.
+func GenerateInsertLayerFromTar(tarPath string, target string, opts *MapOptions) io.ReadCloser {
+	pipeReader, pipeWriter := io.Pipe()
+
+	go func() {
+		pipeWriter.CloseWithError(errors.Wrap(generateInsertLayerFromTar(pipeWriter, tarPath, target, opts), "generate insert layer from tar"))
+	}()
+
+	return pipeReader
+}
+
+// safeJoin joins name onto target (as filepath.Join would), but rejects the
+// result if it escapes target -- which filepath.Join's implicit Clean would
+// otherwise happily allow for a name such as "../../etc/passwd". The
+// returned path uses forward slashes, as required inside a tar header.
+func safeJoin(target, name string) (string, error) {
+	cleanTarget := filepath.Clean(target)
+	joined := filepath.Join(cleanTarget, name)
+	rel, err := filepath.Rel(cleanTarget, joined)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("escapes target directory %q", target)
+	}
+	return filepath.ToSlash(joined), nil
+}
+
+func generateInsertLayerFromTar(w io.Writer, tarPath string, target string, opts *MapOptions) error {
+	fh, err := os.Open(tarPath)
+	if err != nil {
+		return errors.Wrap(err, "open tar source")
+	}
+	defer fh.Close()
+
+	src, err := tarDecompressor(fh)
+	if err != nil {
+		return errors.Wrap(err, "decompress tar source")
+	}
+	if closer, ok := src.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	tr := tar.NewReader(src)
+	tw := tar.NewWriter(w)
+	defer tw.Close()
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "read tar entry")
+		}
+
+		name, err := safeJoin(target, hdr.Name)
+		if err != nil {
+			return errors.Wrapf(err, "entry %q", hdr.Name)
+		}
+		hdr.Name = name
+
+		if hdr.Typeflag == tar.TypeLink && hdr.Linkname != "" {
+			linkname, err := safeJoin(target, hdr.Linkname)
+			if err != nil {
+				return errors.Wrapf(err, "entry %q: link target %q", hdr.Name, hdr.Linkname)
+			}
+			hdr.Linkname = linkname
+		}
+		hdr.Uid, hdr.Gid = mapHostToContainer(hdr.Uid, hdr.Gid, opts)
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return errors.Wrap(err, "write tar header")
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := io.Copy(tw, tr); err != nil {
+				return errors.Wrap(err, "write tar contents")
+			}
+		}
+
+		log.WithFields(log.Fields{"name": hdr.Name}).Debugf("layer: added entry from tarball")
+	}
+
+	return nil
+}
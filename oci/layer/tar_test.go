@@ -0,0 +1,51 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package layer
+
+import "testing"
+
+func TestSafeJoin(t *testing.T) {
+	for _, test := range []struct {
+		target, name string
+		want         string
+		wantErr      bool
+	}{
+		{"/usr/bin", "mybinary", "/usr/bin/mybinary", false},
+		{"/usr/bin", "./mybinary", "/usr/bin/mybinary", false},
+		{"/usr/bin", "sub/mybinary", "/usr/bin/sub/mybinary", false},
+		{"/usr/bin", "..", "", true},
+		{"/usr/bin", "../../etc/passwd", "", true},
+		{"/usr/bin", "sub/../../escape", "", true},
+		{"/usr/bin", "/etc/passwd", "/usr/bin/etc/passwd", false},
+	} {
+		got, err := safeJoin(test.target, test.name)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("safeJoin(%q, %q) = %q, want error", test.target, test.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("safeJoin(%q, %q) unexpected error: %v", test.target, test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("safeJoin(%q, %q) = %q, want %q", test.target, test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+/*
+ * umoci: Umoci Modifies Open Containers' Images
+ * Copyright (C) 2016, 2017, 2018 SUSE LLC.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package layer
+
+// MapOptions specifies the UID and GID mappings used when generating or
+// unpacking layers, so that files are translated between the "on-disk" and
+// "in-container" owner ID spaces.
+type MapOptions struct {
+	// UIDMappings and GIDMappings are the UID and GID mappings to apply when
+	// generating or unpacking a layer.
+	UIDMappings []IDMap
+	GIDMappings []IDMap
+
+	// Rootless specifies whether to apply the rootless trick of only
+	// mapping a single UID/GID into the container (used when we don't have
+	// CAP_SETUID and CAP_SETGID).
+	Rootless bool
+}
+
+// IDMap is a mapping between a host and container ID range, modelled on
+// runtime-spec's LinuxIDMapping.
+type IDMap struct {
+	ContainerID int
+	HostID      int
+	Size        int
+}